@@ -0,0 +1,204 @@
+// Package autolimit discovers the effective memory limit a process is
+// already running under, the way https://github.com/KimMachineGun/automemlimit
+// does for GOMEMLIMIT. Instead of requiring callers to pass the right
+// -limit value by hand, FromCgroup inspects /proc/self/cgroup and the
+// corresponding cgroup v1 or v2 files, falling back to FromSystem (which
+// reads /proc/meminfo's MemTotal) when the cgroup is unlimited. A cgroup
+// that cannot be read is reported as an error, not a fallback.
+package autolimit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/raulk/trampoline/internal/cgroup"
+)
+
+const (
+	// defaultReserve is the fraction of the discovered limit that is
+	// actually handed back, leaving headroom for the parts of the process
+	// (and the Go runtime itself) that don't show up as heap allocations.
+	defaultReserve = 0.9
+
+	cgroupV2Root       = "/sys/fs/cgroup"
+	cgroupV1MemoryRoot = "/sys/fs/cgroup/memory"
+	meminfoFile        = "/proc/meminfo"
+)
+
+// errUnlimited is returned internally when a cgroup memory controller is
+// present but set to "max" (v2) or the kernel's sentinel huge value (v1),
+// i.e. effectively unbounded.
+var errUnlimited = errors.New("autolimit: cgroup memory limit is unlimited")
+
+// Option configures the reserve fraction applied to a discovered limit.
+type Option func(*config)
+
+type config struct {
+	reserve float64
+}
+
+// WithReserve overrides the fraction of the discovered limit that is
+// returned, e.g. 0.9 to keep a 10% safety margin.
+func WithReserve(fraction float64) Option {
+	return func(c *config) { c.reserve = fraction }
+}
+
+func newConfig(opts []Option) config {
+	c := config{reserve: defaultReserve}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// FromCgroup determines whether the process is in a v1 or v2 cgroup
+// hierarchy, reads the active memory limit, and returns that limit scaled
+// by the reserve fraction. If the cgroup has no memory limit configured, it
+// falls back to FromSystem; if the cgroup files cannot be read, it returns
+// an error.
+func FromCgroup(opts ...Option) (uint64, error) {
+	cfg := newConfig(opts)
+
+	limit, err := cgroupMemoryLimit()
+	if errors.Is(err, errUnlimited) {
+		return fromSystem(cfg)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("autolimit: failed to read cgroup memory limit: %w", err)
+	}
+
+	return uint64(float64(limit) * cfg.reserve), nil
+}
+
+// FromSystem returns /proc/meminfo's MemTotal scaled by the reserve
+// fraction, for use when no cgroup memory limit applies.
+func FromSystem(opts ...Option) (uint64, error) {
+	return fromSystem(newConfig(opts))
+}
+
+func fromSystem(cfg config) (uint64, error) {
+	total, err := memTotal()
+	if err != nil {
+		return 0, fmt.Errorf("autolimit: failed to read system memory: %w", err)
+	}
+	return uint64(float64(total) * cfg.reserve), nil
+}
+
+// cgroupMemoryLimit detects the active hierarchy version, via the same
+// detection internal/cgroup uses to decide which API to create the
+// "trampoline" cgroup with, and returns the raw memory limit in bytes, or
+// errUnlimited if no limit is set.
+func cgroupMemoryLimit() (uint64, error) {
+	if cgroup.Detect() == cgroup.V2 {
+		return cgroupV2MemoryLimit()
+	}
+	return cgroupV1MemoryLimit()
+}
+
+func cgroupV2MemoryLimit() (uint64, error) {
+	rel, err := cgroup.OwnPath("")
+	if err != nil {
+		return 0, err
+	}
+
+	raw, err := readFirstLine(filepath.Join(cgroupV2Root, rel, "memory.max"))
+	if err != nil {
+		return 0, err
+	}
+	return parseV2MemoryMax(raw)
+}
+
+// parseV2MemoryMax parses the contents of a cgroup v2 memory.max file:
+// either the literal "max" (no limit configured, so errUnlimited) or a byte
+// count.
+func parseV2MemoryMax(raw string) (uint64, error) {
+	if raw == "max" {
+		return 0, errUnlimited
+	}
+	limit, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing memory.max value %q: %w", raw, err)
+	}
+	return limit, nil
+}
+
+func cgroupV1MemoryLimit() (uint64, error) {
+	rel, err := cgroup.OwnPath("memory")
+	if err != nil {
+		return 0, err
+	}
+
+	raw, err := readFirstLine(filepath.Join(cgroupV1MemoryRoot, rel, "memory.limit_in_bytes"))
+	if err != nil {
+		return 0, err
+	}
+	return parseV1MemoryLimit(raw)
+}
+
+// parseV1MemoryLimit parses the contents of a cgroup v1 memory.limit_in_bytes
+// file. The kernel reports ~9223372036854771712 (math.MaxInt64, rounded down
+// to a page boundary) when no limit has been set, which this reports as
+// errUnlimited.
+func parseV1MemoryLimit(raw string) (uint64, error) {
+	limit, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing memory.limit_in_bytes value %q: %w", raw, err)
+	}
+	if limit > math.MaxInt64-(1<<20) {
+		return 0, errUnlimited
+	}
+	return limit, nil
+}
+
+func memTotal() (uint64, error) {
+	f, err := os.Open(meminfoFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseMemTotal(f)
+}
+
+// parseMemTotal scans /proc/meminfo-formatted content for the MemTotal
+// field, reported in KiB, and returns it in bytes.
+func parseMemTotal(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parsing MemTotal value %q: %w", fields[1], err)
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("MemTotal not found in /proc/meminfo")
+}
+
+func readFirstLine(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return strings.TrimSpace(scanner.Text()), nil
+}
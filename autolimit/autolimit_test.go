@@ -0,0 +1,86 @@
+package autolimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseMemTotal(t *testing.T) {
+	const meminfo = `MemTotal:       16384000 kB
+MemFree:         1234000 kB
+MemAvailable:    8000000 kB
+`
+	got, err := parseMemTotal(strings.NewReader(meminfo))
+	if err != nil {
+		t.Fatalf("parseMemTotal: %s", err)
+	}
+	if want := uint64(16384000 * 1024); got != want {
+		t.Fatalf("parseMemTotal = %d, want %d", got, want)
+	}
+}
+
+func TestParseMemTotalMissing(t *testing.T) {
+	if _, err := parseMemTotal(strings.NewReader("MemFree: 100 kB\n")); err == nil {
+		t.Fatal("expected an error when MemTotal is absent")
+	}
+}
+
+func TestParseV2MemoryMax(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    uint64
+		wantErr error
+	}{
+		{raw: "33554432", want: 33554432},
+		{raw: "max", wantErr: errUnlimited},
+		{raw: "not-a-number", wantErr: strconv.ErrSyntax},
+	}
+	for _, c := range cases {
+		got, err := parseV2MemoryMax(c.raw)
+		if c.wantErr != nil {
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("parseV2MemoryMax(%q) error = %v, want %v", c.raw, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseV2MemoryMax(%q): %s", c.raw, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseV2MemoryMax(%q) = %d, want %d", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestParseV1MemoryLimit(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    uint64
+		wantErr error
+	}{
+		{raw: "33554432", want: 33554432},
+		// The kernel's "no limit set" sentinel, rounded down to a page.
+		{raw: strconv.FormatUint(math.MaxInt64-4095, 10), wantErr: errUnlimited},
+		{raw: "not-a-number", wantErr: strconv.ErrSyntax},
+	}
+	for _, c := range cases {
+		got, err := parseV1MemoryLimit(c.raw)
+		if c.wantErr != nil {
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("parseV1MemoryLimit(%q) error = %v, want %v", c.raw, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseV1MemoryLimit(%q): %s", c.raw, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseV1MemoryLimit(%q) = %d, want %d", c.raw, got, c.want)
+		}
+	}
+}
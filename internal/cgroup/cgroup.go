@@ -0,0 +1,183 @@
+// Package cgroup manages the "/trampoline" cgroup this program runs itself
+// under, transparently picking the v1 or the unified v2 hierarchy depending
+// on what the host has mounted. Modern distros (Fedora 31+, Ubuntu 21.10+,
+// recent Debian) mount only the v2 unified hierarchy, under which the v1
+// APIs this program originally used silently do nothing useful.
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/containerd/cgroups"
+	"github.com/containerd/cgroups/v3/cgroup2"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	cgroupV2Root       = "/sys/fs/cgroup"
+	cgroupV1MemoryRoot = "/sys/fs/cgroup/memory"
+	selfCgroupFile     = "/proc/self/cgroup"
+)
+
+// Version identifies which cgroup hierarchy is active on the host.
+type Version int
+
+const (
+	// V1 is the legacy, per-controller hierarchy.
+	V1 Version = iota
+	// V2 is the unified hierarchy.
+	V2
+)
+
+func (v Version) String() string {
+	switch v {
+	case V1:
+		return "v1"
+	case V2:
+		return "v2"
+	default:
+		return "unknown"
+	}
+}
+
+// unifiedControllersFile exists only when the host has the v2 unified
+// hierarchy mounted.
+const unifiedControllersFile = "/sys/fs/cgroup/cgroup.controllers"
+
+// Detect reports which cgroup hierarchy is active on this host.
+func Detect() Version {
+	if _, err := os.Stat(unifiedControllersFile); err == nil {
+		return V2
+	}
+	return V1
+}
+
+// OwnPath returns this process's path within the hierarchy for the given
+// v1 controller, or, when controller is "", the v2 unified path (the line
+// "0::<path>"), by parsing /proc/self/cgroup. It is exported so other packages that need to
+// locate this process's own cgroup (e.g. autolimit, reading memory.max
+// relative to it) don't have to reimplement this parsing themselves.
+func OwnPath(controller string) (string, error) {
+	f, err := os.Open(selfCgroupFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: hierarchy-ID:controller-list:path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if controller == "" && fields[0] == "0" {
+			return fields[2], nil
+		}
+		for _, c := range strings.Split(fields[1], ",") {
+			if c == controller {
+				return fields[2], nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("cgroup: no %q entry found in %s", controller, selfCgroupFile)
+}
+
+// Group wraps a created cgroup, hiding which hierarchy version it lives
+// under behind a common interface.
+type Group struct {
+	version Version
+	name    string
+	v1      v1.Cgroup
+	v2      *cgroup2.Manager
+}
+
+// New deletes any pre-existing cgroup at name, then creates a new one in
+// whichever hierarchy is active, enforcing a memory limit (and an equal
+// swap limit, to prevent the process from avoiding the OOM killer by
+// swapping).
+func New(name string, limit int64) (*Group, error) {
+	switch Detect() {
+	case V2:
+		return newV2(name, limit)
+	default:
+		return newV1(name, limit)
+	}
+}
+
+func newV1(name string, limit int64) (*Group, error) {
+	path := v1.StaticPath(name)
+
+	if existing, err := v1.Load(v1.V1, path); err == nil {
+		_ = existing.Delete()
+	}
+
+	cg, err := v1.New(v1.V1, path, &specs.LinuxResources{
+		Memory: &specs.LinuxMemory{
+			Limit: &limit,
+			Swap:  &limit,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cgroup: failed to create v1 group: %w", err)
+	}
+	return &Group{version: V1, name: name, v1: cg}, nil
+}
+
+func newV2(name string, limit int64) (*Group, error) {
+	path := "/" + name
+
+	if existing, err := cgroup2.Load(path, cgroup2.WithMountpoint(cgroupV2Root)); err == nil {
+		_ = existing.Delete()
+	}
+
+	res := &cgroup2.Resources{
+		Memory: &cgroup2.Memory{
+			Max:  &limit,
+			Swap: &limit,
+		},
+	}
+	mgr, err := cgroup2.NewManager(cgroupV2Root, path, res)
+	if err != nil {
+		return nil, fmt.Errorf("cgroup: failed to create v2 scope: %w", err)
+	}
+	return &Group{version: V2, name: name, v2: mgr}, nil
+}
+
+// Version reports which hierarchy this Group was created in.
+func (g *Group) Version() Version {
+	return g.version
+}
+
+// Path returns the absolute filesystem path of the cgroup's directory, e.g.
+// for subscribing to its memory.pressure (v2) or memory.pressure_level (v1)
+// files.
+func (g *Group) Path() string {
+	if g.version == V2 {
+		return filepath.Join(cgroupV2Root, g.name)
+	}
+	return filepath.Join(cgroupV1MemoryRoot, g.name)
+}
+
+// AddProc moves the given PID into the cgroup.
+func (g *Group) AddProc(pid int) error {
+	if g.version == V2 {
+		return g.v2.AddProc(uint64(pid))
+	}
+	return g.v1.Add(v1.Process{Pid: pid})
+}
+
+// Delete removes the cgroup.
+func (g *Group) Delete() error {
+	if g.version == V2 {
+		return g.v2.Delete()
+	}
+	return g.v1.Delete()
+}
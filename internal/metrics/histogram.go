@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Histogram is a minimal, hand-rolled Prometheus-style histogram, used to
+// instrument /add and /rel with request latency and observed HeapAlloc
+// delta, so the correlation between allocation bursts and pacer-forced GCs
+// can be graphed when scraped.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // ascending upper bounds; +Inf is implicit
+	counts  []uint64  // len(buckets)+1
+	sum     float64
+	count   uint64
+}
+
+// NewHistogram creates a Histogram with the given ascending bucket upper
+// bounds.
+func NewHistogram(buckets []float64) *Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &Histogram{
+		buckets: sorted,
+		counts:  make([]uint64, len(sorted)+1),
+	}
+}
+
+// Observe records a single value.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sum += v
+	h.count++
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+			return
+		}
+	}
+	h.counts[len(h.counts)-1]++
+}
+
+// WriteProm writes the histogram to w in Prometheus exposition format under
+// the given metric name, with labels formatted as Prometheus label pairs
+// (e.g. `endpoint="add"`), or empty for none.
+func (h *Histogram) WriteProm(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	lbrace, rbrace := "{", "}"
+	if labels == "" {
+		lbrace, rbrace = "", ""
+	}
+
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		bound := "+Inf"
+		if i < len(h.buckets) {
+			bound = strconv.FormatFloat(h.buckets[i], 'g', -1, 64)
+		}
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=%q} %d\n", name, labels, sep, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum%s%s%s %s\n", name, lbrace, labels, rbrace, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s%s%s %d\n", name, lbrace, labels, rbrace, h.count)
+}
+
+// DefaultLatencyBuckets are sane bucket bounds, in seconds, for instrumenting
+// HTTP handler latency.
+var DefaultLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// DefaultByteBuckets are sane bucket bounds, in bytes, for instrumenting
+// heap allocation deltas in the 1KiB-256MiB range.
+var DefaultByteBuckets = []float64{
+	1 << 10, 1 << 14, 1 << 18, 1 << 20, 1 << 22, 1 << 24, 1 << 26, 1 << 28,
+}
+
+// AbsFloat64 is a small helper for turning a signed byte delta (HeapAlloc
+// can shrink as well as grow between samples) into a magnitude suitable for
+// Observe.
+func AbsFloat64(v float64) float64 {
+	return math.Abs(v)
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistogramObserveBuckets(t *testing.T) {
+	h := NewHistogram([]float64{1, 5, 10})
+
+	for _, v := range []float64{0.5, 1, 3, 5, 7, 20} {
+		h.Observe(v)
+	}
+
+	var buf strings.Builder
+	h.WriteProm(&buf, "test_metric", "")
+	out := buf.String()
+
+	wantLines := []string{
+		`test_metric_bucket{le="1"} 2`,
+		`test_metric_bucket{le="5"} 4`,
+		`test_metric_bucket{le="10"} 5`,
+		`test_metric_bucket{le="+Inf"} 6`,
+		`test_metric_sum 36.5`,
+		`test_metric_count 6`,
+	}
+	for _, want := range wantLines {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing line %q; got:\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogramSortsBuckets(t *testing.T) {
+	// NewHistogram must not assume its caller already sorted the bounds.
+	h := NewHistogram([]float64{10, 1, 5})
+	h.Observe(3)
+
+	var buf strings.Builder
+	h.WriteProm(&buf, "m", "")
+	out := buf.String()
+
+	if !strings.Contains(out, `m_bucket{le="1"} 0`) {
+		t.Errorf("expected le=1 bucket first with count 0; got:\n%s", out)
+	}
+	if !strings.Contains(out, `m_bucket{le="5"} 1`) {
+		t.Errorf("expected le=5 bucket to include the observation; got:\n%s", out)
+	}
+}
+
+func TestHistogramWritePromLabels(t *testing.T) {
+	h := NewHistogram(DefaultLatencyBuckets)
+	h.Observe(0.002)
+
+	var buf strings.Builder
+	h.WriteProm(&buf, "trampoline_http_request_duration_seconds", `endpoint="add"`)
+	out := buf.String()
+
+	if !strings.Contains(out, `trampoline_http_request_duration_seconds_bucket{endpoint="add",le="0.005"} 1`) {
+		t.Errorf("expected labeled bucket line; got:\n%s", out)
+	}
+	if !strings.Contains(out, `trampoline_http_request_duration_seconds_count{endpoint="add"} 1`) {
+		t.Errorf("expected labeled count line; got:\n%s", out)
+	}
+}
+
+func TestAbsFloat64(t *testing.T) {
+	cases := []struct {
+		in, want float64
+	}{
+		{5, 5},
+		{-5, 5},
+		{0, 0},
+	}
+	for _, c := range cases {
+		if got := AbsFloat64(c.in); got != c.want {
+			t.Errorf("AbsFloat64(%v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestPromName(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"/gc/heap/live:bytes", "trampoline_gc_heap_live_bytes"},
+		{"/sched/latencies:seconds", "trampoline_sched_latencies_seconds"},
+	}
+	for _, c := range cases {
+		if got := promName(c.in); got != c.want {
+			t.Errorf("promName(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Package metrics writes runtime/metrics samples and ad-hoc request
+// histograms in Prometheus exposition format, so the overshoot scenario
+// this program demonstrates can be scraped and graphed instead of only read
+// off the log. runtime.MemStats, which writeMemStats still uses for the
+// plain-text /stats route, misses GC pause distributions, live heap, and
+// scheduler latencies; runtime/metrics exposes all of those.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// runtimeDescs are the runtime/metrics samples exposed via /metrics, chosen
+// for their relevance to diagnosing the pacer-overshoot scenario: live heap
+// and goal size, free/released memory classes, GC pause distribution, and
+// scheduler latencies (to spot stalls induced by a forced GC).
+var runtimeDescs = []string{
+	"/gc/heap/live:bytes",
+	"/gc/heap/goal:bytes",
+	"/gc/pauses:seconds",
+	"/memory/classes/heap/free:bytes",
+	"/memory/classes/heap/released:bytes",
+	"/sched/latencies:seconds",
+}
+
+// WriteRuntimeMetrics reads the runtime/metrics samples in runtimeDescs and
+// writes them to w in Prometheus exposition format.
+func WriteRuntimeMetrics(w io.Writer) {
+	samples := make([]metrics.Sample, len(runtimeDescs))
+	for i, d := range runtimeDescs {
+		samples[i].Name = d
+	}
+	metrics.Read(samples)
+
+	for _, s := range samples {
+		name := promName(s.Name)
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			fmt.Fprintf(w, "# TYPE %s gauge\n%s %d\n", name, name, s.Value.Uint64())
+		case metrics.KindFloat64:
+			fmt.Fprintf(w, "# TYPE %s gauge\n%s %g\n", name, name, s.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			writeHistogram(w, name, s.Value.Float64Histogram())
+		case metrics.KindBad:
+			// Not supported by this Go runtime's metrics table; skip it
+			// rather than emit a bogus zero value.
+		}
+	}
+}
+
+// promName turns a runtime/metrics name like "/gc/heap/live:bytes" into a
+// Prometheus-friendly one, e.g. "trampoline_gc_heap_live_bytes".
+func promName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	name = strings.NewReplacer("/", "_", ":", "_", "-", "_").Replace(name)
+	return "trampoline_" + name
+}
+
+func writeHistogram(w io.Writer, name string, h *metrics.Float64Histogram) {
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	var cumulative uint64
+	for i, count := range h.Counts {
+		cumulative += count
+		bound := "+Inf"
+		if upper := h.Buckets[i+1]; !math.IsInf(upper, 1) {
+			bound = strconv.FormatFloat(upper, 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
@@ -11,12 +11,58 @@ import (
 	"runtime"
 	"runtime/debug"
 	"strconv"
+	"time"
 
-	"github.com/containerd/cgroups"
 	"github.com/fatih/color"
-	"github.com/opencontainers/runtime-spec/specs-go"
+
+	"github.com/raulk/trampoline/autolimit"
+	"github.com/raulk/trampoline/internal/cgroup"
+	"github.com/raulk/trampoline/internal/metrics"
+	"github.com/raulk/trampoline/pacer"
+	"github.com/raulk/trampoline/pressure"
+	"github.com/raulk/trampoline/scenario"
 )
 
+// autoDropBytes is how many bytes of the oldest retained slabs the pressure
+// callback releases automatically for every memory pressure event, when
+// running in interactive mode.
+const autoDropBytes = 1 << 20
+
+// requestHistograms instrument /add and /rel with request latency and
+// observed HeapAlloc delta, so a Prometheus/Grafana scrape of /metrics can
+// graph the correlation between allocation bursts and pacer-forced GCs.
+var requestHistograms = struct {
+	latency, heapDelta map[string]*metrics.Histogram
+}{
+	latency: map[string]*metrics.Histogram{
+		"add": metrics.NewHistogram(metrics.DefaultLatencyBuckets),
+		"rel": metrics.NewHistogram(metrics.DefaultLatencyBuckets),
+	},
+	heapDelta: map[string]*metrics.Histogram{
+		"add": metrics.NewHistogram(metrics.DefaultByteBuckets),
+		"rel": metrics.NewHistogram(metrics.DefaultByteBuckets),
+	},
+}
+
+// instrument wraps next, recording its latency and the HeapAlloc delta it
+// caused into the endpoint-named histograms in requestHistograms.
+func instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var before runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+
+		next(w, r)
+
+		requestHistograms.latency[endpoint].Observe(time.Since(start).Seconds())
+
+		var after runtime.MemStats
+		runtime.ReadMemStats(&after)
+		delta := metrics.AbsFloat64(float64(after.HeapAlloc) - float64(before.HeapAlloc))
+		requestHistograms.heapDelta[endpoint].Observe(delta)
+	}
+}
+
 // data stores byte slabs that are retained in heap.
 var data [][]byte
 
@@ -56,12 +102,56 @@ var data [][]byte
 func main() {
 	var (
 		interactive = flag.Bool("interactive", false, "start in interactive HTTP mode")
-		limit       = flag.Int64("limit", 32<<20, "memory limit in MiB")
+		limit       = flag.Int64("limit", -1, "memory limit in bytes; if unset, it is auto-detected from the enclosing cgroup (or total system memory)")
 		gc          = flag.Bool("gc", false, "run GC to prevent overallocation")
+		pacerFlag   = flag.Bool("pacer", false, "run a soft memory limit pacer in the background, instead of relying on manual -gc")
+		scriptPath  = flag.String("script", "", "path to a YAML/JSON scenario script to replay, instead of the hardcoded demo below (see scripts/ for canned examples)")
+		tracePath   = flag.String("trace", "", "path to write the -script run's JSONL trace to (default: <script>.trace.jsonl)")
 	)
 
 	flag.Parse()
 
+	if *gc && *pacerFlag {
+		panic("-gc and -pacer are mutually exclusive; pick one mitigation strategy to demo")
+	}
+
+	// Scenario replay only needs the alloc/release/gc primitives, not the
+	// cgroup machinery the other modes demonstrate, so it runs before any of
+	// that (which requires privileged cgroup access this mode's raison
+	// d'être, reproducible CI traces, should not depend on). It only resolves
+	// -limit (which may shell out to autolimit.FromCgroup) when -pacer is
+	// also requested, since that's the only thing in this branch that needs
+	// it; pass -limit explicitly alongside -pacer if cgroup auto-detection
+	// isn't available in your environment.
+	if *scriptPath != "" {
+		var stopPacer func()
+		if *pacerFlag {
+			resolveLimit(limit)
+			_, stopPacer = startPacer(uint64(*limit))
+		}
+		err := runScenario(*scriptPath, *tracePath)
+		// Stop and report the pacer's counters before deciding how to exit,
+		// rather than deferring: log.Fatalf below skips deferred calls, and
+		// those counters are most useful on exactly the failure path they'd
+		// otherwise be lost on.
+		if stopPacer != nil {
+			stopPacer()
+		}
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		return
+	}
+
+	resolveLimit(limit)
+
+	var p *pacer.Pacer
+	if *pacerFlag {
+		var stop func()
+		p, stop = startPacer(uint64(*limit))
+		defer stop()
+	}
+
 	ch := make(chan struct{}, 1)
 	go func() {
 		for range ch {
@@ -72,34 +162,23 @@ func main() {
 	fmt.Println("setting max heap to:", maxHeap)
 	debug.SetMaxHeap(maxHeap, ch)
 
-	// delete the cgroup if it exists.
-	if cgroup, err := cgroups.Load(cgroups.V1, cgroups.StaticPath("/trampoline")); err == nil {
-		log.Printf("prexisting cgroup deleted")
-		_ = cgroup.Delete()
-	}
-
-	// create the cgroup.
-	cgroup, err := cgroups.New(cgroups.V1, cgroups.StaticPath("/trampoline"), &specs.LinuxResources{
-		Memory: &specs.LinuxMemory{
-			Limit: limit,
-			Swap:  limit,
-		},
-	})
+	// create the cgroup, in whichever hierarchy version the host has mounted.
+	group, err := cgroup.New("trampoline", *limit)
 	if err != nil {
 		panic(err)
 	}
-	defer cgroup.Delete()
+	defer group.Delete()
 
-	log.Printf("cgroup created: trampoline")
+	log.Printf("cgroup created: trampoline (hierarchy: %s)", group.Version())
 
-	if err := cgroup.Add(cgroups.Process{Pid: os.Getpid()}); err != nil {
+	if err := group.AddProc(os.Getpid()); err != nil {
 		panic(fmt.Sprintf("failed to add process to group: %s", err))
 	}
 
 	log.Printf("process added to cgroup")
 
 	if *interactive {
-		interactiveMode()
+		interactiveMode(p, group)
 		return
 	}
 
@@ -158,16 +237,68 @@ func main() {
 	log.Printf(color.YellowString("Congratulations, this program did not crash!"))
 }
 
+// resolveLimit auto-detects *limit from the enclosing cgroup (or total
+// system memory) when the -limit flag was left at its default, panicking if
+// detection fails. Callers that don't strictly need a resolved *limit (e.g.
+// -script mode without -pacer) should avoid calling this, so they keep
+// working in environments without cgroup filesystem access.
+func resolveLimit(limit *int64) {
+	if *limit >= 0 {
+		return
+	}
+	detected, err := autolimit.FromCgroup()
+	if err != nil {
+		panic(fmt.Sprintf("failed to auto-detect memory limit: %s", err))
+	}
+	*limit = int64(detected)
+	log.Printf("auto-detected memory limit: %d bytes", *limit)
+}
+
+// startPacer starts a soft-limit pacer against limit bytes, and returns it
+// alongside a stop func that stops it and logs its final counters. Callers
+// should invoke the stop func themselves (deferred or not) rather than
+// duplicating this start/stop/log sequence inline.
+func startPacer(limit uint64) (p *pacer.Pacer, stop func()) {
+	p = pacer.Start(limit)
+	log.Printf(color.GreenString("soft-limit pacer started"))
+	return p, func() {
+		p.Stop()
+		counters := p.Counters()
+		log.Printf("pacer stopped: forced %d GCs, approached the limit %d times", counters.ForcedGC, counters.Approached)
+	}
+}
+
 // interactiveMode places this program in interactive HTTP mode. This will expose
-// an HTTP endpoint on 0.0.0.0:1112, with 5 routes:
+// an HTTP endpoint on 0.0.0.0:1112, with the following routes:
 //
 // * /add?bytes=n, to add a byte slab of the specified amount to the heap.
 // * /rel?bytes=n, to release as many bytes as specified.
 // * /gc, to trigger GC.
 // * /stats, to get memory stats.
 // * /reset, to clear all retained byte slabs.
-func interactiveMode() {
-	http.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+//
+// If p is non-nil, its counters are reported alongside /stats so that the
+// "no pacer", "manual GC" and "soft-limit pacer" demo modes can be compared
+// while the server is running. group's cgroup hierarchy is also reported
+// via /stats, so users can verify which code path they are exercising.
+//
+// Two further routes subscribe to kernel-level memory pressure for group:
+//
+// * /pressure, an SSE stream of pressure events as they are observed.
+// * /drop?bytes=n, to release the oldest n bytes from data; also invoked automatically, with a fixed size, whenever a pressure event fires.
+//
+// * /metrics exposes runtime/metrics (GC pauses, live heap, scheduler latencies, ...) and the pacer's counters in Prometheus exposition format, and /add and /rel are instrumented there with per-request latency and HeapAlloc delta histograms.
+func interactiveMode(p *pacer.Pacer, group *cgroup.Group) {
+	mon, err := pressure.Watch(group, func(e pressure.Event) {
+		log.Printf("memory pressure event: level=%s avg_stall_10s=%s; dropping %d bytes", e.Level, e.AvgStall10s, autoDropBytes)
+		release(autoDropBytes)
+	})
+	if err != nil {
+		log.Printf("memory pressure monitoring unavailable: %s", err)
+	} else {
+		defer mon.Stop()
+	}
+	http.HandleFunc("/add", instrument("add", func(w http.ResponseWriter, r *http.Request) {
 		bytes, err := parseBytes(r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -181,9 +312,9 @@ func interactiveMode() {
 
 		var stats runtime.MemStats
 		writeMemStats(&stats, w)
-	})
+	}))
 
-	http.HandleFunc("/rel", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/rel", instrument("rel", func(w http.ResponseWriter, r *http.Request) {
 		bytes, err := parseBytes(r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -198,12 +329,81 @@ func interactiveMode() {
 
 		var stats runtime.MemStats
 		writeMemStats(&stats, w)
-	})
+	}))
 
 	http.HandleFunc("/gc", gc)
-	http.HandleFunc("/stats", stats)
+	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats(w, r)
+		_, _ = fmt.Fprintln(w, "cgroup hierarchy:", group.Version())
+		if p != nil {
+			counters := p.Counters()
+			_, _ = fmt.Fprintln(w, "pacer:")
+			_, _ = fmt.Fprintln(w, "\tforced gc:", counters.ForcedGC)
+			_, _ = fmt.Fprintln(w, "\tapproached limit:", counters.Approached)
+		}
+	})
 	http.HandleFunc("/reset", reset)
 
+	http.HandleFunc("/pressure", func(w http.ResponseWriter, r *http.Request) {
+		if mon == nil {
+			http.Error(w, "memory pressure monitoring unavailable on this host", http.StatusServiceUnavailable)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-mon.Events():
+				if !ok {
+					return
+				}
+				_, _ = fmt.Fprintf(w, "data: level=%s avg_stall_10s=%s\n\n", e.Level, e.AvgStall10s)
+				flusher.Flush()
+			}
+		}
+	})
+
+	http.HandleFunc("/drop", func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := parseBytes(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dropped, _ := release(bytes)
+
+		_, _ = fmt.Fprintln(w, "dropped: ", dropped)
+		_, _ = fmt.Fprintln(w)
+
+		var stats runtime.MemStats
+		writeMemStats(&stats, w)
+	})
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		metrics.WriteRuntimeMetrics(w)
+
+		if p != nil {
+			counters := p.Counters()
+			_, _ = fmt.Fprintf(w, "# TYPE trampoline_pacer_forced_gc_total counter\ntrampoline_pacer_forced_gc_total %d\n", counters.ForcedGC)
+			_, _ = fmt.Fprintf(w, "# TYPE trampoline_pacer_approached_total counter\ntrampoline_pacer_approached_total %d\n", counters.Approached)
+		}
+
+		for _, endpoint := range []string{"add", "rel"} {
+			labels := fmt.Sprintf("endpoint=%q", endpoint)
+			requestHistograms.latency[endpoint].WriteProm(w, "trampoline_http_request_duration_seconds", labels)
+			requestHistograms.heapDelta[endpoint].WriteProm(w, "trampoline_http_heap_alloc_delta_bytes", labels)
+		}
+	})
+
 	fmt.Println("http endpoint started")
 
 	_ = http.ListenAndServe("0.0.0.0:1112", http.DefaultServeMux)
@@ -281,3 +481,65 @@ func writeMemStats(stats *runtime.MemStats, w io.Writer) {
 	_, _ = fmt.Fprintln(w, "\tnext gc:", stats.NextGC)
 	_, _ = fmt.Fprintln(w, "\tnum gc:", stats.NumGC)
 }
+
+// scenarioExecutor backs a scenario.Script with the same add/release/gc
+// primitives the HTTP interactive mode exposes.
+type scenarioExecutor struct{}
+
+func (scenarioExecutor) Alloc(bytes int) {
+	add(bytes)
+}
+
+func (scenarioExecutor) Release(bytes int) (released, notReleased int) {
+	return release(bytes)
+}
+
+func (scenarioExecutor) GC() {
+	runtime.GC()
+}
+
+func (scenarioExecutor) Snapshot() scenario.Snapshot {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return scenario.Snapshot{HeapAlloc: stats.HeapAlloc, NumGC: stats.NumGC}
+}
+
+// runScenario replays the script at scriptPath against scenarioExecutor,
+// recording a per-step JSONL trace to tracePath (or, if empty,
+// scriptPath+".trace.jsonl"). It returns an error if the script fails to
+// parse or one of its "assert" steps fails, so CI can catch a regression in
+// Go's pacer behavior by running the canned scripts in scripts/:
+// scripts/overshoot.json reproduces the overshoot and is expected to fail
+// its "assert" step with no mitigation flags; scripts/gc-fix.json mitigates
+// it with an embedded "gc" step and is expected to pass unconditionally;
+// scripts/pacer-mitigated.json has the same allocation pattern as
+// overshoot.json with no embedded "gc" step, so it only passes when run
+// with "-pacer -limit 33554432" to let the soft-limit pacer (started above,
+// before this function, since it samples runtime.MemStats directly and
+// needs no cgroup) force the GC instead. The caller, not this function,
+// decides how to exit the process, so a pacer started alongside it gets a
+// chance to stop and report its counters first.
+func runScenario(scriptPath, tracePath string) error {
+	script, err := scenario.Load(scriptPath)
+	if err != nil {
+		return fmt.Errorf("failed to load scenario script: %w", err)
+	}
+
+	if tracePath == "" {
+		tracePath = scriptPath + ".trace.jsonl"
+	}
+	traceFile, err := os.Create(tracePath)
+	if err != nil {
+		return fmt.Errorf("failed to create trace file: %w", err)
+	}
+	defer traceFile.Close()
+
+	log.Printf("replaying scenario %s (trace: %s)", scriptPath, tracePath)
+
+	if err := scenario.Run(script, scenarioExecutor{}, traceFile); err != nil {
+		return fmt.Errorf("scenario failed: %w", err)
+	}
+
+	log.Printf(color.GreenString("scenario completed successfully"))
+	return nil
+}
@@ -0,0 +1,181 @@
+// Package pacer implements a user-space approximation of the GOMEMLIMIT
+// "soft memory limit" policy described in Go's soft memory limit proposal
+// (golang/go#48409), for use on runtimes that predate it (pre-1.19) and as
+// an extra safety net on runtimes that already have it.
+//
+// A Pacer samples runtime.MemStats on a fixed interval and estimates live
+// heap footprint as HeapAlloc + HeapIdle - HeapReleased (the portion of the
+// address space the runtime is holding onto, whether in use or merely not
+// yet returned to the OS). Once that estimate crosses a configurable
+// fraction of the limit, it forces a GC cycle and returns memory to the OS
+// via debug.FreeOSMemory. It also lowers GOGC while near the ceiling, so the
+// runtime's own pacer schedules the next collection sooner, and restores the
+// original GOGC once HeapAlloc drops back below a low-water mark. This
+// hysteresis avoids thrashing GOGC up and down on every sample.
+package pacer
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultInterval is how often the Pacer samples runtime.MemStats.
+	defaultInterval = 200 * time.Millisecond
+	// defaultThreshold is the fraction of the limit at which the Pacer
+	// forces a GC, mirroring the 0.9 figure used in the soft memory limit
+	// proposal.
+	defaultThreshold = 0.9
+	// defaultLowWater is the fraction of the limit HeapAlloc must fall
+	// below before the Pacer restores the original GOGC.
+	defaultLowWater = 0.5
+	// defaultLoweredGOGC is the GOGC percentage applied while the Pacer is
+	// actively pacing near the ceiling.
+	defaultLoweredGOGC = 10
+)
+
+// Option configures a Pacer at construction time.
+type Option func(*Pacer)
+
+// WithInterval overrides the sampling interval.
+func WithInterval(d time.Duration) Option {
+	return func(p *Pacer) { p.interval = d }
+}
+
+// WithThreshold overrides the fraction of the limit that triggers a forced
+// GC, e.g. 0.9 for 90%.
+func WithThreshold(fraction float64) Option {
+	return func(p *Pacer) { p.threshold = fraction }
+}
+
+// WithLowWaterMark overrides the fraction of the limit HeapAlloc must drop
+// below before the original GOGC is restored.
+func WithLowWaterMark(fraction float64) Option {
+	return func(p *Pacer) { p.lowWater = fraction }
+}
+
+// WithLoweredGOGC overrides the GOGC percentage applied while pacing.
+func WithLoweredGOGC(percent int) Option {
+	return func(p *Pacer) { p.loweredGOGC = percent }
+}
+
+// Counters holds the Prometheus-style counters a Pacer exposes. They are
+// monotonically increasing for the lifetime of the Pacer.
+type Counters struct {
+	// ForcedGC counts how many times the Pacer called runtime.GC() because
+	// the heap estimate crossed the threshold.
+	ForcedGC uint64
+	// Approached counts how many samples observed the heap estimate at or
+	// above the threshold, whether or not that particular sample forced a
+	// new GC (it always does today, but the counter is kept distinct from
+	// ForcedGC so the two can diverge if that changes).
+	Approached uint64
+}
+
+// Pacer runs a background goroutine that enforces a soft memory limit.
+type Pacer struct {
+	limit       uint64
+	interval    time.Duration
+	threshold   float64
+	lowWater    float64
+	baseGOGC    int
+	loweredGOGC int
+	lowered     bool
+
+	forcedGC   uint64
+	approached uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Start creates a Pacer enforcing limit bytes and launches its background
+// sampling goroutine. Callers must call Stop to release the goroutine.
+func Start(limit uint64, opts ...Option) *Pacer {
+	p := &Pacer{
+		limit:       limit,
+		interval:    defaultInterval,
+		threshold:   defaultThreshold,
+		lowWater:    defaultLowWater,
+		baseGOGC:    100,
+		loweredGOGC: defaultLoweredGOGC,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	// debug.SetGCPercent is the only way to read the current GOGC, and it
+	// mutates as a side effect; set it right back so a Pacer that never
+	// crosses threshold (and so never lowers/restores GOGC itself) doesn't
+	// silently leave GOGC pinned at our default for its whole lifetime.
+	old := debug.SetGCPercent(p.baseGOGC)
+	debug.SetGCPercent(old)
+	p.baseGOGC = old
+
+	go p.run()
+	return p
+}
+
+func (p *Pacer) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.sample()
+		}
+	}
+}
+
+func (p *Pacer) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	estimate := stats.HeapAlloc + stats.HeapIdle - stats.HeapReleased
+	ceiling := uint64(float64(p.limit) * p.threshold)
+
+	if estimate >= ceiling {
+		atomic.AddUint64(&p.approached, 1)
+		if !p.lowered {
+			debug.SetGCPercent(p.loweredGOGC)
+			p.lowered = true
+		}
+		runtime.GC()
+		debug.FreeOSMemory()
+		atomic.AddUint64(&p.forcedGC, 1)
+		return
+	}
+
+	lowWaterMark := uint64(float64(p.limit) * p.lowWater)
+	if p.lowered && stats.HeapAlloc < lowWaterMark {
+		debug.SetGCPercent(p.baseGOGC)
+		p.lowered = false
+	}
+}
+
+// Counters returns a snapshot of the Pacer's counters.
+func (p *Pacer) Counters() Counters {
+	return Counters{
+		ForcedGC:   atomic.LoadUint64(&p.forcedGC),
+		Approached: atomic.LoadUint64(&p.approached),
+	}
+}
+
+// Stop halts the background sampling goroutine and restores GOGC to its
+// original value if the Pacer had lowered it.
+func (p *Pacer) Stop() {
+	close(p.stop)
+	<-p.done
+	if p.lowered {
+		debug.SetGCPercent(p.baseGOGC)
+	}
+}
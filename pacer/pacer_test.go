@@ -0,0 +1,50 @@
+package pacer
+
+import (
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+// readGOGC returns the current GOGC percentage without leaving it changed.
+func readGOGC() int {
+	old := debug.SetGCPercent(100)
+	debug.SetGCPercent(old)
+	return old
+}
+
+func TestStartStopRestoresCallerGOGC(t *testing.T) {
+	prev := debug.SetGCPercent(300)
+	defer debug.SetGCPercent(prev)
+
+	// A limit far above any plausible heap size so the pacer never crosses
+	// its threshold and never lowers/restores GOGC on its own; Stop must
+	// still leave GOGC as it found it.
+	p := Start(1<<62, WithInterval(5*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	p.Stop()
+
+	if got := readGOGC(); got != 300 {
+		t.Fatalf("GOGC after Stop = %d, want 300 (the caller's original value)", got)
+	}
+}
+
+func TestPacerLowersAndRestoresGOGC(t *testing.T) {
+	prev := debug.SetGCPercent(250)
+	defer debug.SetGCPercent(prev)
+
+	// A limit of 0 bytes means every sample is "at or above" the threshold,
+	// so the pacer lowers GOGC on its very first tick.
+	p := Start(0, WithInterval(5*time.Millisecond), WithLoweredGOGC(5))
+	time.Sleep(20 * time.Millisecond)
+
+	if got := readGOGC(); got != 5 {
+		t.Fatalf("GOGC while pacing = %d, want 5 (the lowered value)", got)
+	}
+
+	p.Stop()
+
+	if got := readGOGC(); got != 250 {
+		t.Fatalf("GOGC after Stop = %d, want 250 (the caller's original value)", got)
+	}
+}
@@ -0,0 +1,260 @@
+// Package pressure subscribes to kernel-level cgroup memory pressure, which
+// debug.SetMaxHeap's notification channel misses entirely since that one
+// only fires on Go-internal heap pressure. It misses pressure induced by
+// other processes sharing the same cgroup (or memory.pressure_level
+// "critical" events on v1), which is exactly the kind of pressure that
+// precedes an OOM kill from outside the Go runtime's view.
+//
+// On cgroup v2, it writes a threshold line to memory.pressure and polls the
+// file for POLLPRI events, as documented in the kernel's PSI (pressure
+// stall information) interface. On cgroup v1, it registers for
+// memory.pressure_level "critical" notifications via the cgroup's
+// eventfd-based cgroup.event_control mechanism. Either way, events are
+// fanned out on a typed channel and to any registered callbacks, so library
+// consumers can drop caches, reject new requests, or call runtime.GC().
+package pressure
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/raulk/trampoline/internal/cgroup"
+)
+
+// Level classifies how severe a pressure event is, following PSI's "some"
+// (at least one task stalled) and "full" (all non-idle tasks stalled)
+// categories. v1's memory.pressure_level "critical" notifications are
+// reported as Full, the closest analogue.
+type Level int
+
+const (
+	// Some indicates at least one task was stalled on memory for the
+	// registered window.
+	Some Level = iota
+	// Full indicates all non-idle tasks were stalled on memory.
+	Full
+)
+
+func (l Level) String() string {
+	switch l {
+	case Some:
+		return "some"
+	case Full:
+		return "full"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports a single memory pressure notification.
+type Event struct {
+	Level Level
+	// AvgStall10s estimates the time spent stalled over the trailing 10s
+	// window, derived from PSI's avg10 percentage. It is always zero for
+	// v1's memory.pressure_level, which carries no stall-time statistics.
+	AvgStall10s time.Duration
+}
+
+// Callback is invoked, in addition to the Events channel, for every Event.
+type Callback func(Event)
+
+// pollThreshold registers for PSI "some" stalls of at least 150ms within
+// any 1s window, matching the threshold recommended in the kernel's PSI
+// documentation for interactive workloads.
+const pollThreshold = "some 150000 1000000\n"
+
+// Monitor watches a cgroup for memory pressure until Stop is called.
+type Monitor struct {
+	events    chan Event
+	callbacks []Callback
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// Watch starts monitoring group for memory pressure. callbacks, if any, are
+// invoked synchronously from the monitor's background goroutine for every
+// Event, in addition to it being sent on Events().
+func Watch(group *cgroup.Group, callbacks ...Callback) (*Monitor, error) {
+	m := &Monitor{
+		events:    make(chan Event, 16),
+		callbacks: callbacks,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	switch group.Version() {
+	case cgroup.V2:
+		f, err := os.OpenFile(group.Path()+"/memory.pressure", os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("pressure: failed to open memory.pressure: %w", err)
+		}
+		if _, err := f.WriteString(pollThreshold); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("pressure: failed to register PSI threshold: %w", err)
+		}
+		go m.watchV2(f)
+	default:
+		eventFd, err := registerV1Critical(group.Path())
+		if err != nil {
+			return nil, err
+		}
+		go m.watchV1(eventFd)
+	}
+
+	return m, nil
+}
+
+// Events returns the channel Events are fanned out on.
+func (m *Monitor) Events() <-chan Event {
+	return m.events
+}
+
+// Stop halts the background goroutine and closes the Events channel.
+func (m *Monitor) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+func (m *Monitor) emit(e Event) {
+	for _, cb := range m.callbacks {
+		cb(e)
+	}
+	select {
+	case m.events <- e:
+	default:
+		// Drop the event rather than block the poll loop if no one is
+		// reading fast enough; callbacks have already run.
+	}
+}
+
+func (m *Monitor) watchV2(f *os.File) {
+	defer close(m.done)
+	defer close(m.events)
+	defer f.Close()
+
+	fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLPRI | unix.POLLERR}}
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		n, err := unix.Poll(fds, 1000)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return
+		}
+		if n == 0 || fds[0].Revents&unix.POLLPRI == 0 {
+			continue
+		}
+
+		avg10, err := readAvg10(f)
+		if err != nil {
+			continue
+		}
+		m.emit(Event{Level: Some, AvgStall10s: time.Duration(avg10 / 100 * 10 * float64(time.Second))})
+	}
+}
+
+func (m *Monitor) watchV1(eventFd int) {
+	defer close(m.done)
+	defer close(m.events)
+	defer unix.Close(eventFd)
+
+	fds := []unix.PollFd{{Fd: int32(eventFd), Events: unix.POLLIN}}
+	buf := make([]byte, 8)
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		n, err := unix.Poll(fds, 1000)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return
+		}
+		if n == 0 || fds[0].Revents&unix.POLLIN == 0 {
+			continue
+		}
+
+		if _, err := unix.Read(eventFd, buf); err != nil {
+			continue
+		}
+		m.emit(Event{Level: Full})
+	}
+}
+
+// registerV1Critical implements the cgroup v1 "notification API": an
+// eventfd is created, then handed to the cgroup along with an fd on
+// memory.pressure_level and the level to watch for, by writing
+// "<event_fd> <pressure_level_fd> critical" to cgroup.event_control.
+// Reading from the eventfd thereafter blocks until the kernel posts a
+// notification.
+func registerV1Critical(groupPath string) (int, error) {
+	levelFile, err := os.Open(groupPath + "/memory.pressure_level")
+	if err != nil {
+		return -1, fmt.Errorf("pressure: failed to open memory.pressure_level: %w", err)
+	}
+	defer levelFile.Close()
+
+	controlFile, err := os.OpenFile(groupPath+"/cgroup.event_control", os.O_WRONLY, 0)
+	if err != nil {
+		return -1, fmt.Errorf("pressure: failed to open cgroup.event_control: %w", err)
+	}
+	defer controlFile.Close()
+
+	eventFd, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		return -1, fmt.Errorf("pressure: failed to create eventfd: %w", err)
+	}
+
+	registration := fmt.Sprintf("%d %d critical", eventFd, levelFile.Fd())
+	if _, err := controlFile.WriteString(registration); err != nil {
+		unix.Close(eventFd)
+		return -1, fmt.Errorf("pressure: failed to register for critical pressure events: %w", err)
+	}
+
+	return eventFd, nil
+}
+
+// readAvg10 reads the "some" line of a freshly-triggered memory.pressure
+// file and returns its avg10 field, the percentage of time stalled over the
+// trailing 10s window.
+func readAvg10(f *os.File) (float64, error) {
+	if _, err := f.Seek(0, 0); err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "some ") {
+			continue
+		}
+		for _, field := range strings.Fields(line)[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) == 2 && kv[0] == "avg10" {
+				return strconv.ParseFloat(kv[1], 64)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("pressure: no avg10 field found")
+}
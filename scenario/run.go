@@ -0,0 +1,93 @@
+package scenario
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Snapshot captures the memory stats relevant to the overshoot scenario at
+// a single point in time.
+type Snapshot struct {
+	HeapAlloc uint64 `json:"heap_alloc"`
+	NumGC     uint32 `json:"num_gc"`
+}
+
+// Executor performs the primitive operations a Script drives. main's
+// add/release/gc functions and runtime.ReadMemStats back these for the real
+// program; tests can substitute a fake.
+type Executor interface {
+	Alloc(bytes int)
+	Release(bytes int) (released, notReleased int)
+	GC()
+	Snapshot() Snapshot
+}
+
+// TraceEntry is one line of the JSONL trace Run produces: a snapshot taken
+// immediately after executing a step.
+type TraceEntry struct {
+	Step int      `json:"step"`
+	At   Duration `json:"at"`
+	Op   Op       `json:"op"`
+	Snapshot
+}
+
+// AssertionError is returned by Run when an "assert" step's invariant does
+// not hold; main treats it as fatal, exiting with a non-zero status so CI
+// can detect a regression in Go's pacer behavior.
+type AssertionError struct {
+	Step    int
+	Message string
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("scenario: assertion failed at step %d: %s", e.Step, e.Message)
+}
+
+// Run executes script against exec in order, sleeping between steps to
+// honor their relative "at" timestamps, and writes a Snapshot-per-step
+// trace to trace in JSONL format. It returns an *AssertionError if an
+// "assert" step's invariant is violated.
+func Run(script Script, exec Executor, trace io.Writer) error {
+	enc := json.NewEncoder(trace)
+
+	var elapsed Duration
+	for i, step := range script {
+		if wait := time.Duration(step.At - elapsed); wait > 0 {
+			time.Sleep(wait)
+		}
+		elapsed = step.At
+
+		switch step.Op {
+		case OpAlloc:
+			exec.Alloc(int(step.Size))
+		case OpRelease:
+			exec.Release(int(step.Size))
+		case OpGC:
+			exec.GC()
+		case OpAssert:
+			if err := assert(i, step, exec.Snapshot()); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("scenario: unknown op %q at step %d", step.Op, i)
+		}
+
+		entry := TraceEntry{Step: i, At: step.At, Op: step.Op, Snapshot: exec.Snapshot()}
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("scenario: failed to write trace entry for step %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func assert(step int, s Step, snap Snapshot) error {
+	if s.HeapBelow > 0 && snap.HeapAlloc >= uint64(s.HeapBelow) {
+		return &AssertionError{Step: step, Message: fmt.Sprintf("heap_below: heap alloc %d >= limit %d", snap.HeapAlloc, s.HeapBelow)}
+	}
+	if s.MaxGCs > 0 && snap.NumGC > s.MaxGCs {
+		return &AssertionError{Step: step, Message: fmt.Sprintf("max_gcs: num gc %d > limit %d", snap.NumGC, s.MaxGCs)}
+	}
+	return nil
+}
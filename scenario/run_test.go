@@ -0,0 +1,98 @@
+package scenario
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/raulk/trampoline/pacer"
+)
+
+// memExecutor is a real (not mocked) Executor backed by retained byte
+// slabs, mirroring main's add/release/gc primitives closely enough to
+// reproduce the same heap behavior those primitives exhibit against the
+// real Go runtime.
+type memExecutor struct {
+	data [][]byte
+}
+
+func (e *memExecutor) Alloc(bytes int) {
+	m := make([]byte, bytes)
+	for i := range m {
+		m[i] = 0xff
+	}
+	e.data = append(e.data, m)
+}
+
+func (e *memExecutor) Release(bytes int) (released, notReleased int) {
+	rem := bytes
+	for i := 0; i < len(e.data) && rem > 0; i++ {
+		head := e.data[i]
+		if l := len(head); rem >= l {
+			e.data[i] = nil
+			rem -= l
+		} else {
+			slice := make([]byte, len(head)-rem)
+			copy(slice, head)
+			e.data[i] = slice
+			rem = 0
+		}
+	}
+	return bytes - rem, rem
+}
+
+func (e *memExecutor) GC() {
+	runtime.GC()
+}
+
+func (e *memExecutor) Snapshot() Snapshot {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return Snapshot{HeapAlloc: stats.HeapAlloc, NumGC: stats.NumGC}
+}
+
+// TestCannedScriptsDiverge exercises the real pacer-overshoot demonstration
+// against the three scripts shipped in scripts/, over the real Go runtime
+// (no mocked Executor), to prove they actually distinguish "broken" from
+// "fixed" instead of all trivially passing regardless of mitigation:
+// overshoot.json (no mitigation) is expected to fail its "assert" step,
+// while gc-fix.json (an embedded "gc" step) and pacer-mitigated.json (the
+// same allocation pattern as overshoot.json, but mitigated externally by a
+// running pacer.Pacer) are expected to pass.
+func TestCannedScriptsDiverge(t *testing.T) {
+	t.Run("overshoot.json fails without mitigation", func(t *testing.T) {
+		script, err := Load("../scripts/overshoot.json")
+		if err != nil {
+			t.Fatalf("Load: %s", err)
+		}
+		var assertErr *AssertionError
+		if err := Run(script, &memExecutor{}, io.Discard); !errors.As(err, &assertErr) {
+			t.Fatalf("Run(overshoot.json) error = %v, want an *AssertionError", err)
+		}
+	})
+
+	t.Run("gc-fix.json passes with an embedded gc step", func(t *testing.T) {
+		script, err := Load("../scripts/gc-fix.json")
+		if err != nil {
+			t.Fatalf("Load: %s", err)
+		}
+		if err := Run(script, &memExecutor{}, io.Discard); err != nil {
+			t.Fatalf("Run(gc-fix.json): %s", err)
+		}
+	})
+
+	t.Run("pacer-mitigated.json passes only while a pacer runs", func(t *testing.T) {
+		script, err := Load("../scripts/pacer-mitigated.json")
+		if err != nil {
+			t.Fatalf("Load: %s", err)
+		}
+
+		p := pacer.Start(33554432)
+		defer p.Stop()
+
+		if err := Run(script, &memExecutor{}, io.Discard); err != nil {
+			t.Fatalf("Run(pacer-mitigated.json) with pacer running: %s", err)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+// Package scenario lets the overshoot demonstration be driven by a
+// declarative script instead of the hardcoded narrative in main, so that
+// the classic overshoot, the -gc fix, and a pacer-mitigated run can all be
+// captured as fixtures and diffed in CI when Go's GC pacer behavior
+// changes across versions.
+//
+// A script is a YAML or JSON list of timed steps against the same
+// alloc/release/gc primitives the HTTP interactive mode exposes, e.g.:
+//
+//   - {at: 0s, op: alloc, size: 28MiB}
+//   - {at: 2s, op: release, size: 28MiB}
+//   - {at: 2.1s, op: gc}
+//   - {at: 3s, op: assert, heap_below: 32MiB}
+package scenario
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Op identifies the operation a Step performs.
+type Op string
+
+const (
+	OpAlloc   Op = "alloc"
+	OpRelease Op = "release"
+	OpGC      Op = "gc"
+	OpAssert  Op = "assert"
+)
+
+// Step is a single timed operation in a Script.
+type Step struct {
+	At        Duration `json:"at" yaml:"at"`
+	Op        Op       `json:"op" yaml:"op"`
+	Size      Size     `json:"size,omitempty" yaml:"size,omitempty"`
+	HeapBelow Size     `json:"heap_below,omitempty" yaml:"heap_below,omitempty"`
+	MaxGCs    uint32   `json:"max_gcs,omitempty" yaml:"max_gcs,omitempty"`
+}
+
+// Script is an ordered sequence of Steps, timed relative to its own start.
+type Script []Step
+
+// Load reads and parses a Script from path, dispatching on its extension:
+// .yaml/.yml is parsed as YAML, anything else as JSON.
+func Load(path string) (Script, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("scenario: failed to read %s: %w", path, err)
+	}
+
+	var script Script
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &script)
+	default:
+		err = json.Unmarshal(raw, &script)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scenario: failed to parse %s: %w", path, err)
+	}
+	return script, nil
+}
+
+// Duration is a time.Duration that unmarshals from strings like "2.1s",
+// via time.ParseDuration.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) unmarshal(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.unmarshal(s)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.unmarshal(s)
+}
+
+// Size is a byte count that unmarshals from plain integers or strings with
+// a binary unit suffix, e.g. "28MiB".
+type Size uint64
+
+// sizeUnits is ordered longest suffix first: "B" is itself a suffix of
+// "KiB", "MiB" and "GiB", so checking it first would truncate those to an
+// unparseable numeral (e.g. "28MiB" would become "28Mi").
+var sizeUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+func (s *Size) unmarshal(raw string) error {
+	raw = strings.TrimSpace(raw)
+	for _, unit := range sizeUnits {
+		suffix, multiplier := unit.suffix, unit.multiplier
+		if strings.HasSuffix(raw, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(raw, suffix), 64)
+			if err != nil {
+				return fmt.Errorf("invalid size %q: %w", raw, err)
+			}
+			*s = Size(n * float64(multiplier))
+			return nil
+		}
+	}
+	n, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %w", raw, err)
+	}
+	*s = Size(n)
+	return nil
+}
+
+func (s *Size) UnmarshalJSON(b []byte) error {
+	// Sizes may be given as a bare JSON number (bytes) or a string with a
+	// unit suffix.
+	var n uint64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	return s.unmarshal(str)
+}
+
+func (s *Size) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n uint64
+	if err := unmarshal(&n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	return s.unmarshal(str)
+}
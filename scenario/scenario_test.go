@@ -0,0 +1,103 @@
+package scenario
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestDurationUnmarshalJSON(t *testing.T) {
+	var d Duration
+	if err := json.Unmarshal([]byte(`"2.1s"`), &d); err != nil {
+		t.Fatalf("UnmarshalJSON: %s", err)
+	}
+	if want := Duration(2100 * time.Millisecond); d != want {
+		t.Fatalf("got %s, want %s", d, want)
+	}
+}
+
+func TestDurationUnmarshalJSONInvalid(t *testing.T) {
+	var d Duration
+	if err := json.Unmarshal([]byte(`"not-a-duration"`), &d); err == nil {
+		t.Fatal("expected an error for an invalid duration string")
+	}
+}
+
+func TestDurationUnmarshalYAML(t *testing.T) {
+	var d Duration
+	if err := yaml.Unmarshal([]byte(`2.1s`), &d); err != nil {
+		t.Fatalf("UnmarshalYAML: %s", err)
+	}
+	if want := Duration(2100 * time.Millisecond); d != want {
+		t.Fatalf("got %s, want %s", d, want)
+	}
+}
+
+func TestSizeUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want Size
+	}{
+		{raw: `3355443`, want: 3355443},
+		{raw: `"3355443"`, want: 3355443},
+		{raw: `"28MiB"`, want: 28 << 20},
+		{raw: `"1KiB"`, want: 1 << 10},
+		{raw: `"1GiB"`, want: 1 << 30},
+		{raw: `"512B"`, want: 512},
+	}
+	for _, c := range cases {
+		var s Size
+		if err := json.Unmarshal([]byte(c.raw), &s); err != nil {
+			t.Errorf("Unmarshal(%s): %s", c.raw, err)
+			continue
+		}
+		if s != c.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", c.raw, s, c.want)
+		}
+	}
+}
+
+func TestSizeUnmarshalJSONInvalid(t *testing.T) {
+	var s Size
+	if err := json.Unmarshal([]byte(`"28XiB"`), &s); err == nil {
+		t.Fatal("expected an error for an unrecognized unit suffix")
+	}
+}
+
+func TestSizeUnmarshalYAML(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want Size
+	}{
+		{raw: `3355443`, want: 3355443},
+		{raw: `28MiB`, want: 28 << 20},
+	}
+	for _, c := range cases {
+		var s Size
+		if err := yaml.Unmarshal([]byte(c.raw), &s); err != nil {
+			t.Errorf("Unmarshal(%s): %s", c.raw, err)
+			continue
+		}
+		if s != c.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", c.raw, s, c.want)
+		}
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	script, err := Load("../scripts/overshoot.json")
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	if len(script) != 4 {
+		t.Fatalf("got %d steps, want 4", len(script))
+	}
+	if script[0].Op != OpAlloc || script[0].Size != 30200000 {
+		t.Fatalf("unexpected first step: %+v", script[0])
+	}
+	if last := script[len(script)-1]; last.Op != OpAssert || last.HeapBelow != 33554432 {
+		t.Fatalf("unexpected last step: %+v", last)
+	}
+}